@@ -44,6 +44,7 @@ func (g *guistate) Add(name string, value interface{}) {
 	if v, ok := value.(Slicer); ok {
 		g.Quants[name] = v
 	}
+	g.Broadcast("add", name)
 }
 
 // Once Params/Quants have been declared and added,
@@ -52,8 +53,11 @@ func (g *guistate) PrepareServer() {
 	GUI.Page = gui.NewPage(templText, &GUI)
 	GUI.OnAnyEvent(func() {
 		GUI.eventCacheBreaker++
+		GUI.pushUpdate()
 	})
 
+	g.registerWS()
+	g.registerRPC()
 	http.Handle("/", GUI)
 	http.Handle("/render/", &renderer)
 	//http.HandleFunc("/plot/", servePlot)
@@ -186,6 +190,7 @@ func (g *guistate) SetBusy(busy bool) {
 	g.busy = busy
 	g.disableControls(busy)
 	updateKeepAlive() // needed after long busy period to avoid browser considered disconnected
+	g.Broadcast("busy", busy)
 }
 
 func (g *guistate) disableControls(busy bool) {
@@ -0,0 +1,291 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// rpcRequest is a JSON-RPC 2.0 request, as POSTed to /rpc.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      interface{}     `json:"id"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response.
+type rpcResponse struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Result  interface{} `json:"result,omitempty"`
+	Error   *rpcError   `json:"error,omitempty"`
+	ID      interface{} `json:"id"`
+}
+
+// rpcError is a structured JSON-RPC 2.0 error. Data is optional extra
+// detail; the methods below compile no source, and none of them set it.
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// rpcMethods dispatches to the same World registry Compile/Eval uses,
+// but with typed parameters instead of building a Go-syntax string.
+var rpcMethods = map[string]func(json.RawMessage) (interface{}, *rpcError){
+	"engine.setRegion": rpcSetRegion,
+	"engine.run":       rpcRun,
+	"engine.steps":     rpcSteps,
+	"solver.set":       rpcSolverSet,
+	"mesh.set":         rpcMeshSet,
+	"quant.slice":      rpcQuantSlice,
+	"job.poll":         rpcJobPoll,
+}
+
+// registerRPC mounts the /rpc endpoint on the engine's HTTP mux.
+func (g *guistate) registerRPC() {
+	http.HandleFunc("/rpc", serveRPC)
+}
+
+func serveRPC(w http.ResponseWriter, r *http.Request) {
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRPC(w, rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+		return
+	}
+
+	fn, ok := rpcMethods[req.Method]
+	if !ok {
+		writeRPC(w, rpcResponse{JSONRPC: "2.0", ID: req.ID, Error: &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}})
+		return
+	}
+
+	result, rpcErr := fn(req.Params)
+	writeRPC(w, rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr})
+}
+
+func writeRPC(w http.ResponseWriter, resp rpcResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// rpcSetRegion sets a region of a Param directly against GUI.Params,
+// the same registry Add populates -- no Go-syntax string is built or
+// compiled, so there is nothing for a malicious name/field to inject
+// into. Reported as a job since setRegion runs on the run loop.
+func rpcSetRegion(params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Name   string    `json:"name"`
+		Region int       `json:"region"`
+		Value  []float64 `json:"value"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	jobID := startJob(func() error {
+		return setParamRegion(p.Name, p.Region, p.Value)
+	})
+	return map[string]string{"job": jobID}, nil
+}
+
+// rpcSolverSet sets a field of the Solver directly, the same way
+// rpcSetRegion sets a Param: no string is built or compiled.
+func rpcSolverSet(params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Field string  `json:"field"`
+		Value float64 `json:"value"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	jobID := startJob(func() error {
+		return setSolverField(p.Field, p.Value)
+	})
+	return map[string]string{"job": jobID}, nil
+}
+
+// setParamRegion looks up name in the same registry Add populates and
+// sets one region directly, without building or compiling any source.
+func setParamRegion(name string, region int, value []float64) error {
+	param, ok := GUI.Params[name]
+	if !ok {
+		return fmt.Errorf("no such parameter: %v", name)
+	}
+	if len(value) != param.NComp() {
+		return fmt.Errorf("%v needs %v components, got %v", name, param.NComp(), len(value))
+	}
+	if region < 0 {
+		return fmt.Errorf("%v: region must be >= 0 (uniform set is not supported over RPC)", name)
+	}
+	param.setRegion(region, value)
+	return nil
+}
+
+// setSolverField sets one field of the global Solver directly, without
+// building or compiling any source.
+func setSolverField(field string, value float64) error {
+	switch field {
+	case "maxdt":
+		Solver.MaxDt = value
+	case "mindt":
+		Solver.MinDt = value
+	case "maxerr":
+		Solver.MaxErr = value
+	case "fixdt":
+		Solver.FixDt = value
+	default:
+		return fmt.Errorf("no such solver field: %v", field)
+	}
+	return nil
+}
+
+func rpcMeshSet(params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		N []int     `json:"n"`
+		C []float64 `json:"c"`
+		P []int     `json:"p"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	if len(p.N) != 3 || len(p.C) != 3 || len(p.P) != 3 {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "n, c and p must each have 3 elements"}
+	}
+	jobID := startJob(func() error {
+		SetMesh(p.N[X], p.N[Y], p.N[Z], p.C[X]*1e-9, p.C[Y]*1e-9, p.C[Z]*1e-9, p.P)
+		return nil
+	})
+	return map[string]string{"job": jobID}, nil
+}
+
+func rpcRun(params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Seconds float64 `json:"seconds"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	jobID := startJob(func() error {
+		Run(p.Seconds)
+		return nil
+	})
+	return map[string]string{"job": jobID}, nil
+}
+
+func rpcSteps(params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		N int `json:"n"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	jobID := startJob(func() error {
+		Steps(p.N)
+		return nil
+	})
+	return map[string]string{"job": jobID}, nil
+}
+
+// rpcQuantSlice resolves the quantity so a follow-up HTTP GET on
+// /render/<name>/<comp> can fetch the binary body; the RPC call itself
+// just validates the request. layer is not accepted here: /render
+// doesn't read it, and accepting a parameter that does nothing is
+// worse than not having it.
+func rpcQuantSlice(params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Name string `json:"name"`
+		Comp int    `json:"comp"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	if _, ok := GUI.Quants[p.Name]; !ok {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "no such quantity: " + p.Name}
+	}
+	return map[string]string{"url": fmt.Sprintf("/render/%v/%v", p.Name, p.Comp)}, nil
+}
+
+// rpcJobPoll reports whether a job id returned by engine.run/engine.steps/
+// mesh.set has finished, as an alternative to subscribing to the "job"
+// topic over /ws.
+func rpcJobPoll(params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Job string `json:"job"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	jobsMutex.Lock()
+	job, ok := jobs[p.Job]
+	if ok && job.Done {
+		// Polled once it's finished: the caller has what it needs,
+		// so evict it rather than holding it for the process lifetime.
+		delete(jobs, p.Job)
+	}
+	jobsMutex.Unlock()
+	if !ok {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "no such job: " + p.Job}
+	}
+	return job, nil
+}
+
+// jobs holds long-running calls so they can be polled by id or
+// subscribed to over the /ws connection added alongside this endpoint.
+// rpcJobPoll evicts an entry once it reports Done, so a long-running
+// simulation driven over RPC doesn't leak one entry per call.
+var (
+	jobsMutex sync.Mutex
+	jobs      = make(map[string]*rpcJob)
+)
+
+type rpcJob struct {
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+}
+
+// startJob runs fn on the run loop (same as the polled handlers do via
+// Inject) and tracks completion under a job id so slow calls don't
+// have to block the HTTP request.
+func startJob(fn func() error) string {
+	id := fmt.Sprintf("job%v", rand.Int())
+	jobsMutex.Lock()
+	jobs[id] = &rpcJob{}
+	jobsMutex.Unlock()
+
+	go func() {
+		Inject <- func() {
+			err := safeCall(fn)
+			jobsMutex.Lock()
+			job := jobs[id]
+			job.Done = true
+			if err != nil {
+				job.Error = err.Error()
+			}
+			jobsMutex.Unlock()
+			GUI.Broadcast("job", map[string]interface{}{"job": id, "done": true, "error": job.Error})
+		}
+	}()
+	return id
+}
+
+// safeCall runs fn, converting a panic on the run loop (e.g. an
+// out-of-range region) into an error so it reaches the job's Error
+// field instead of crashing the solver.
+func safeCall(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return fn()
+}
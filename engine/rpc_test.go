@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeParam is a minimal Param for exercising setParamRegion without
+// depending on the CUDA-backed RegionwiseScalar that GUI.Params
+// normally holds.
+type fakeParam struct {
+	ncomp  int
+	values map[int][]float64
+}
+
+func (p *fakeParam) NComp() int                { return p.ncomp }
+func (p *fakeParam) Unit() string              { return "" }
+func (p *fakeParam) IsUniform() bool           { return false }
+func (p *fakeParam) getRegion(r int) []float64 { return p.values[r] }
+func (p *fakeParam) setRegion(r int, v []float64) {
+	p.values[r] = append([]float64{}, v...)
+}
+
+func TestSetParamRegion(t *testing.T) {
+	GUI.Params = map[string]Param{"msat": &fakeParam{ncomp: 1, values: map[int][]float64{}}}
+
+	if err := setParamRegion("msat", 3, []float64{8e5}); err != nil {
+		t.Fatalf("setParamRegion: %v", err)
+	}
+	if got := GUI.Params["msat"].(*fakeParam).values[3]; len(got) != 1 || got[0] != 8e5 {
+		t.Fatalf("setParamRegion did not set region 3: %v", got)
+	}
+
+	if err := setParamRegion("nosuch", 0, []float64{1}); err == nil {
+		t.Fatal("expected error for unknown parameter")
+	}
+	if err := setParamRegion("msat", 0, []float64{1, 2}); err == nil {
+		t.Fatal("expected error for wrong component count")
+	}
+	if err := setParamRegion("msat", -1, []float64{1}); err == nil {
+		t.Fatal("expected error for negative region (uniform set is not supported over RPC)")
+	}
+}
+
+func TestSetSolverField(t *testing.T) {
+	if err := setSolverField("maxdt", 5e-12); err != nil {
+		t.Fatalf("setSolverField(maxdt): %v", err)
+	}
+	if Solver.MaxDt != 5e-12 {
+		t.Fatalf("MaxDt = %v, want 5e-12", Solver.MaxDt)
+	}
+	if err := setSolverField("bogus", 1); err == nil {
+		t.Fatal("expected error for unknown solver field")
+	}
+}
+
+func TestRPCMeshSetRejectsWrongLength(t *testing.T) {
+	_, rpcErr := rpcMeshSet([]byte(`{"n":[1,2],"c":[1,1,1],"p":[0,0,0]}`))
+	if rpcErr == nil {
+		t.Fatal("expected rpcInvalidParams for n with 2 elements")
+	}
+	if rpcErr.Code != rpcInvalidParams {
+		t.Fatalf("code = %v, want rpcInvalidParams", rpcErr.Code)
+	}
+}
+
+// TestStartJobAndPoll drives a fake run loop off Inject so startJob's
+// completion path runs, then checks rpcJobPoll reports Done and evicts
+// the job afterwards instead of keeping it around for the process
+// lifetime.
+func TestStartJobAndPoll(t *testing.T) {
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case f := <-Inject:
+				f()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer close(stop)
+
+	id := startJob(func() error { return nil })
+
+	var job *rpcJob
+	for i := 0; i < 1000; i++ {
+		result, rpcErr := rpcJobPoll([]byte(`{"job":"` + id + `"}`))
+		if rpcErr != nil {
+			t.Fatalf("rpcJobPoll: %v", rpcErr.Message)
+		}
+		job = result.(*rpcJob)
+		if job.Done {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !job.Done {
+		t.Fatal("job never completed")
+	}
+
+	if _, rpcErr := rpcJobPoll([]byte(`{"job":"` + id + `"}`)); rpcErr == nil {
+		t.Fatal("expected job to be evicted after being polled once Done")
+	}
+}
@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"encoding/json"
+	"github.com/barnex/cuda5/cu"
+	"golang.org/x/net/websocket"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// wsUpdate is the JSON delta pushed to connected browsers by pushUpdate
+// when an event fires, at most once per wsPushInterval.
+type wsUpdate struct {
+	Step     int     `json:"step"`
+	Time     float64 `json:"time"`
+	Dt       float64 `json:"dt"`
+	LastErr  float64 `json:"lasterr"`
+	MemFree  int64   `json:"memfree"`
+	CacheKey int     `json:"cacheKey"`
+}
+
+// wsMsg is an inbound message from the browser, symmetric to wsUpdate:
+// it lets cli commands and parameter edits reach the engine without a
+// form POST. For "set", Name/Region/Value address a GUI.Params entry
+// exactly like engine.setRegion over /rpc -- the same setParamRegion
+// applies the edit, so there is one code path for both transports.
+type wsMsg struct {
+	Type   string    `json:"type"`   // "cli" or "set"
+	Cmd    string    `json:"cmd"`    // console command, for "cli"
+	Name   string    `json:"name"`   // Param name, for "set"
+	Region int       `json:"region"` // region index, for "set"
+	Value  []float64 `json:"value"`  // new value, for "set"
+}
+
+// wsConn is one connected browser. Send has a bounded queue so a slow
+// client can't stall the broadcast; when full, the oldest queued message
+// is dropped to make room.
+type wsConn struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+const wsSendQueue = 32
+
+// wsHub fans broadcasts out to every connected wsConn.
+type wsHub struct {
+	mutex sync.Mutex
+	conns map[*wsConn]bool
+}
+
+var hub = wsHub{conns: make(map[*wsConn]bool)}
+
+func (h *wsHub) add(c *wsConn) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.conns[c] = true
+}
+
+func (h *wsHub) remove(c *wsConn) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.conns, c)
+	close(c.send)
+}
+
+// broadcast enqueues msg on every connected socket, dropping the oldest
+// queued message for a connection that can't keep up.
+func (h *wsHub) broadcast(msg []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for c := range h.conns {
+		select {
+		case c.send <- msg:
+		default:
+			select {
+			case <-c.send:
+			default:
+			}
+			select {
+			case c.send <- msg:
+			default:
+			}
+		}
+	}
+}
+
+// Broadcast lets other subsystems (solver, table, save, ...) push their
+// own progress events to connected browsers over /ws.
+func (g *guistate) Broadcast(topic string, payload interface{}) {
+	b, err := json.Marshal(struct {
+		Topic   string      `json:"topic"`
+		Payload interface{} `json:"payload"`
+	}{topic, payload})
+	if err != nil {
+		log.Println("gui: broadcast:", err)
+		return
+	}
+	hub.broadcast(b)
+}
+
+// serveWS upgrades the connection to a WebSocket and pumps wsUpdates
+// to it until it disconnects, while relaying inbound wsMsg's to Eval
+// (as the polled "cli" handler in gui.go does) or setParamRegion.
+func (g *guistate) serveWS(ws *websocket.Conn) {
+	c := &wsConn{conn: ws, send: make(chan []byte, wsSendQueue)}
+	hub.add(c)
+	defer hub.remove(c)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var m wsMsg
+			if err := websocket.JSON.Receive(ws, &m); err != nil {
+				return
+			}
+			g.handleWS(m)
+		}
+	}()
+
+	for {
+		select {
+		case b, ok := <-c.send:
+			if !ok {
+				return
+			}
+			if _, err := ws.Write(b); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// handleWS dispatches an inbound websocket message: "cli" is Eval'd on
+// the run loop, as the polled "cli" handler in gui.go does, and "set"
+// goes through setParamRegion against GUI.Params, the actual engine
+// state, not gui.Page.Set which only relabels a display widget.
+func (g *guistate) handleWS(m wsMsg) {
+	switch m.Type {
+	case "cli":
+		cmd := m.Cmd
+		Inject <- func() { Eval(cmd) }
+	case "set":
+		Inject <- func() {
+			if err := setParamRegion(m.Name, m.Region, m.Value); err != nil {
+				LogOutput(err.Error())
+			}
+		}
+	default:
+		log.Println("gui: ws: unknown message type:", m.Type)
+	}
+}
+
+// registerWS mounts the /ws endpoint. Updates reach it through
+// pushUpdate, which PrepareServer hooks onto OnAnyEvent.
+func (g *guistate) registerWS() {
+	http.Handle("/ws", websocket.Handler(g.serveWS))
+}
+
+// wsPushInterval throttles pushUpdate: OnAnyEvent fires on every single
+// solver step, and a CUDA driver call plus a JSON marshal and fan-out
+// on every step would be a real cost on the hot path of the run loop.
+const wsPushInterval = 100 * time.Millisecond
+
+var (
+	wsPushMutex sync.Mutex
+	wsLastPush  time.Time
+)
+
+// pushUpdate broadcasts the current solver state, driven off
+// eventCacheBreaker so browsers repaint soon after something changes
+// rather than waiting for the next poll -- but no more often than
+// wsPushInterval, regardless of how fast the run loop is stepping.
+//
+// Solver.* and cu.MemGetInfo() are only safe to touch from the run-loop
+// goroutine that owns the CUDA context, same as OnUpdate in gui.go, so
+// the reads happen inside InjectAndWait rather than on whatever
+// goroutine OnAnyEvent fires us from.
+func (g *guistate) pushUpdate() {
+	wsPushMutex.Lock()
+	due := time.Since(wsLastPush) >= wsPushInterval
+	if due {
+		wsLastPush = time.Now()
+	}
+	wsPushMutex.Unlock()
+	if !due {
+		return
+	}
+
+	var update wsUpdate
+	InjectAndWait(func() {
+		memfree, _ := cu.MemGetInfo()
+		update = wsUpdate{
+			Step:     Solver.NSteps,
+			Time:     Time,
+			Dt:       Solver.Dt_si,
+			LastErr:  Solver.LastErr,
+			MemFree:  memfree / (1024 * 1024),
+			CacheKey: g.eventCacheBreaker,
+		}
+	})
+	g.Broadcast("update", update)
+}
@@ -0,0 +1,40 @@
+package httpfs
+
+import (
+	"math/rand"
+	"time"
+)
+
+// BackoffPolicy configures the retry behavior of Client.do when the
+// transport fails (connection refused, EOF, temporary net errors).
+// Each retry waits Initial * Factor^attempt, capped at Max, plus up to
+// 50% jitter so many workers reconnecting to the same head node don't
+// all retry in lockstep. MaxElapsed bounds the total time spent
+// retrying a single request; zero means retry forever.
+type BackoffPolicy struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Factor     float64
+	MaxElapsed time.Duration
+}
+
+// DefaultBackoff is used by Dial unless overridden with Client.SetBackoff.
+var DefaultBackoff = BackoffPolicy{
+	Initial:    100 * time.Millisecond,
+	Max:        30 * time.Second,
+	Factor:     2,
+	MaxElapsed: 0,
+}
+
+// next returns the delay before the given retry attempt (0-based).
+func (b BackoffPolicy) next(attempt int) time.Duration {
+	d := float64(b.Initial)
+	for i := 0; i < attempt; i++ {
+		d *= b.Factor
+	}
+	if max := float64(b.Max); d > max {
+		d = max
+	}
+	jitter := d * 0.5 * rand.Float64()
+	return time.Duration(d + jitter)
+}
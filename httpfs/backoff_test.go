@@ -0,0 +1,34 @@
+package httpfs
+
+import "testing"
+
+func TestBackoffPolicyNext(t *testing.T) {
+	policy := BackoffPolicy{Initial: 100, Max: 1000, Factor: 2}
+
+	cases := []struct {
+		attempt int
+		want    int64 // unjittered delay; next() adds up to 50% on top
+	}{
+		{0, 100},
+		{1, 200},
+		{2, 400},
+		{3, 800},
+		{4, 1000}, // capped at Max
+		{10, 1000},
+	}
+	for _, c := range cases {
+		d := policy.next(c.attempt)
+		if int64(d) < c.want || int64(d) > c.want+c.want/2 {
+			t.Errorf("next(%v) = %v, want in [%v, %v]", c.attempt, d, c.want, c.want+c.want/2)
+		}
+	}
+}
+
+func TestBackoffPolicyNextNeverNegative(t *testing.T) {
+	policy := BackoffPolicy{Initial: 1, Max: 1 << 30, Factor: 2}
+	for attempt := 0; attempt < 64; attempt++ {
+		if policy.next(attempt) < 0 {
+			t.Fatalf("next(%v) went negative, Factor^attempt likely overflowed", attempt)
+		}
+	}
+}
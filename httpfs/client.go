@@ -1,6 +1,7 @@
 package httpfs
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,12 +10,30 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
 // An httpfs Client provides access to an httpfs file system.
 type Client struct {
-	serverAddr string // server address
-	client     http.Client
+	serverAddr  string    // server address, used when the client is not a cluster
+	ring        *hashRing // nil unless Dial'd with DialCluster
+	client      http.Client
+	backoff     BackoffPolicy
+	onReconnect func()
+
+	mutex sync.Mutex
+	fds   map[uintptr]*fdInfo // open files, keyed by server-side fd, for replay after a reconnect
+}
+
+// fdInfo is enough to transparently re-OPEN a file on the server after
+// it has clearly restarted (fd table gone / unknown fd).
+type fdInfo struct {
+	host   string // backend that owns this fd, per the consistent-hash ring
+	path   string
+	flag   int
+	perm   os.FileMode
+	offset int64
 }
 
 // Dial sets up a Client to access files served on addr.
@@ -24,10 +43,72 @@ func Dial(addr string) (*Client, error) {
 	if _, err := net.ResolveTCPAddr("tcp", addr); err != nil {
 		return nil, fmt.Errorf("httpfs: dial %v: %v", addr, err)
 	}
-	fs := &Client{serverAddr: addr}
+	fs := &Client{serverAddr: addr, backoff: DefaultBackoff, fds: make(map[uintptr]*fdInfo)}
+	return fs, nil
+}
+
+// DialCluster sets up a Client that stripes files across several
+// backend addresses, chosen by consistent hashing on the file path, so
+// a large simulation's output isn't bottlenecked on a single storage
+// node. An error is returned only if an address cannot be resolved by
+// net.ResolveTCPAddr.
+func DialCluster(addrs []string) (*Client, error) {
+	for _, addr := range addrs {
+		if _, err := net.ResolveTCPAddr("tcp", addr); err != nil {
+			return nil, fmt.Errorf("httpfs: dial %v: %v", addr, err)
+		}
+	}
+	fs := &Client{ring: newHashRing(addrs), backoff: DefaultBackoff, fds: make(map[uintptr]*fdInfo)}
 	return fs, nil
 }
 
+// AddNode adds a backend to the cluster, rebuilding the ring so only
+// the paths that now hash to it need to migrate. Panics if the Client
+// was set up with Dial rather than DialCluster.
+func (f *Client) AddNode(addr string) {
+	f.ring.AddNode(addr)
+}
+
+// RemoveNode removes a backend from the cluster, rebuilding the ring
+// so only the paths that hashed to it need to migrate. Panics if the
+// Client was set up with Dial rather than DialCluster.
+func (f *Client) RemoveNode(addr string) {
+	f.ring.RemoveNode(addr)
+}
+
+// Ring returns a snapshot of the current consistent-hash ring, to pass
+// as oldRing to MigrateOutputDir after a later AddNode/RemoveNode.
+func (f *Client) Ring() *hashRing {
+	return newHashRing(f.ring.Nodes())
+}
+
+// hostFor returns the backend address responsible for path: the single
+// server for a plain Dial'd Client, or the ring-chosen backend for a
+// DialCluster'd one.
+func (f *Client) hostFor(path string) string {
+	if f.ring != nil {
+		return f.ring.Get(path)
+	}
+	return f.serverAddr
+}
+
+// SetBackoff overrides the retry policy used when the transport fails.
+func (f *Client) SetBackoff(policy BackoffPolicy) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.backoff = policy
+}
+
+// OnReconnect registers a callback invoked whenever do() successfully
+// re-establishes contact with the server after one or more failed
+// attempts, so long-running simulations can log and continue instead
+// of crashing on a transient network blip.
+func (f *Client) OnReconnect(fn func()) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.onReconnect = fn
+}
+
 // Open opens a file for reading, similar to os.Open.
 func (f *Client) Open(name string) (*File, error) {
 	return f.OpenFile(name, os.O_RDONLY, 0)
@@ -40,8 +121,10 @@ func (f *Client) Create(name string) (*File, error) {
 
 // OpenFile is similar to os.OpenFile. Most users will use Open or Create instead.
 func (f *Client) OpenFile(name string, flag int, perm os.FileMode) (*File, error) {
+	host := f.hostFor(name)
+
 	// prepare URL for OPEN request
-	u := url.URL{Scheme: "http", Host: f.serverAddr, Path: name}
+	u := url.URL{Scheme: "http", Host: host, Path: name}
 	q := u.Query()
 	q.Set("flag", fmt.Sprint(flag))
 	q.Set("perm", fmt.Sprint(uint32(perm)))
@@ -57,30 +140,247 @@ func (f *Client) OpenFile(name string, flag int, perm os.FileMode) (*File, error
 		return nil, fmt.Errorf(`httpfs open "%v": invalid argument`, name)
 	}
 
-	// prepare *File
-	fdURL := url.URL{Scheme: "http", Host: f.serverAddr, Path: fmt.Sprint(fd)}
+	f.mutex.Lock()
+	f.fds[uintptr(fd)] = &fdInfo{host: host, path: name, flag: flag, perm: perm}
+	f.mutex.Unlock()
+
+	// prepare *File, host and all, so subsequent fd operations go to the right backend
+	fdURL := url.URL{Scheme: "http", Host: host, Path: fmt.Sprint(fd)}
 	file := &File{client: f, u: fdURL, name: name, fd: uintptr(fd)}
 	return file, nil
 }
 
+// closeFD forgets a server-side fd once the caller is done with it.
+func (f *Client) closeFD(fd uintptr) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.fds, fd)
+}
+
+// setOffset records how far fd has been read/written, so it can be
+// re-seeked after a transparent reopen.
+func (f *Client) setOffset(fd uintptr, offset int64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if info, ok := f.fds[fd]; ok {
+		info.offset = offset
+	}
+}
+
+// hostOf returns the backend host currently on record for fd, so a
+// *File can keep its URL in sync after doFD transparently reopens it.
+func (f *Client) hostOf(fd uintptr) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if info, ok := f.fds[fd]; ok {
+		return info.host
+	}
+	return ""
+}
+
+// reopen re-OPENs a file whose server-side fd has gone stale (the
+// server restarted and lost its fd table) and seeks back to where the
+// client thought it was, returning the new fd.
+func (f *Client) reopen(fd uintptr) (uintptr, error) {
+	f.mutex.Lock()
+	info, ok := f.fds[fd]
+	f.mutex.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("httpfs: reopen: unknown fd %v", fd)
+	}
+
+	u := url.URL{Scheme: "http", Host: info.host, Path: info.path}
+	q := u.Query()
+	q.Set("flag", fmt.Sprint(info.flag))
+	q.Set("perm", fmt.Sprint(uint32(info.perm)))
+	u.RawQuery = q.Encode()
+
+	resp := f.do("OPEN", u.String(), nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf(`httpfs reopen "%v": status %v: "%s"`, info.path, resp.StatusCode, resp.Header.Get(X_ERROR))
+	}
+	newFd := readUInt(resp.Body)
+	if newFd < 0 {
+		return 0, fmt.Errorf(`httpfs reopen "%v": invalid argument`, info.path)
+	}
+
+	if info.offset != 0 {
+		seekURL := url.URL{Scheme: "http", Host: info.host, Path: fmt.Sprint(newFd)}
+		q := seekURL.Query()
+		q.Set("offset", fmt.Sprint(info.offset))
+		seekURL.RawQuery = q.Encode()
+		seekResp := f.do("SEEK", seekURL.String(), nil)
+		seekResp.Body.Close()
+	}
+
+	f.mutex.Lock()
+	delete(f.fds, fd)
+	f.fds[uintptr(newFd)] = info
+	f.mutex.Unlock()
+	return uintptr(newFd), nil
+}
+
+// doFD does a HTTP request against an open fd (read/write/seek/close).
+// If the server reports the fd unknown -- it restarted and lost its fd
+// table -- doFD transparently reopens the file and re-seeks before
+// retrying once, returning the (possibly updated) fd the caller should
+// use from then on. body is passed as a byte slice rather than an
+// io.Reader because it may need to be replayed against the new fd.
+func (f *Client) doFD(fd uintptr, method string, URL string, body []byte) (*http.Response, uintptr) {
+	resp := f.do(method, URL, bodyReader(body))
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(resp.Header.Get(X_ERROR), "unknown fd") {
+		return resp, fd
+	}
+	newFd, err := f.reopen(fd)
+	if err != nil {
+		return resp, fd
+	}
+	resp.Body.Close()
+	u, eURL := url.Parse(URL)
+	panicOn(eURL)
+	u.Path = fmt.Sprint(newFd)
+	return f.do(method, u.String(), bodyReader(body)), newFd
+}
+
+func bodyReader(body []byte) io.Reader {
+	if body == nil {
+		return nil
+	}
+	return bytes.NewReader(body)
+}
+
 // Mkdir creates a new directory with the specified name and permission bits. If there is an error, it will be of type *PathError.
 //func (f*Client) Mkdir(name string, perm FileMode) error{
 //
 //}
 
-// do Does a HTTP request. If an error occurs, it returns a fake response
-// with status Teapot and the error message in the header.
+// do does a HTTP request, retrying with truncated exponential backoff on
+// connection-refused / EOF / temporary net errors. MaxElapsed (zero means
+// retry forever) bounds how long it keeps trying before giving up and
+// returning a fake response with status Teapot and the error message in
+// the header, same as before.
 func (f *Client) do(method string, URL string, body io.Reader) *http.Response {
-	req, eReq := http.NewRequest(method, URL, body)
-	panicOn(eReq)
-	resp, eResp := f.client.Do(req)
-	if eResp != nil {
-		return &http.Response{
-			StatusCode: http.StatusTeapot, // indicates that it's not a real HTTP status
-			Header:     http.Header{X_ERROR: []string{eResp.Error()}},
-			Body:       ioutil.NopCloser(strings.NewReader(""))}
+	// bodies may need to be replayed on retry, so buffer them up front.
+	var bodyBytes []byte
+	if body != nil {
+		bodyBytes, _ = ioutil.ReadAll(body)
+	}
+
+	f.mutex.Lock()
+	policy := f.backoff
+	onReconnect := f.onReconnect
+	f.mutex.Unlock()
+
+	start := time.Now()
+	failed := false
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader
+		if bodyBytes != nil {
+			reqBody = strings.NewReader(string(bodyBytes))
+		}
+		req, eReq := http.NewRequest(method, URL, reqBody)
+		panicOn(eReq)
+		resp, eResp := f.client.Do(req)
+		if eResp == nil {
+			if failed && onReconnect != nil {
+				onReconnect()
+			}
+			return resp
+		}
+		if !isTemporary(eResp) || (policy.MaxElapsed != 0 && time.Since(start) > policy.MaxElapsed) {
+			return &http.Response{
+				StatusCode: http.StatusTeapot, // indicates that it's not a real HTTP status
+				Header:     http.Header{X_ERROR: []string{eResp.Error()}},
+				Body:       ioutil.NopCloser(strings.NewReader(""))}
+		}
+		failed = true
+		time.Sleep(policy.next(attempt))
+	}
+}
+
+// isTemporary reports whether a transport error (connection refused, EOF,
+// temporary net.Error, ...) is worth retrying. http.Client.Do always wraps
+// the underlying error in a *url.Error, so it must be unwrapped first --
+// checking the wrapper itself against io.EOF never matches, and the
+// wrapper's own Temporary()/Timeout() just pass through to an underlying
+// dial/EOF error that Go no longer marks temporary, which would otherwise
+// make this always report false instead of reaching the checks below.
+func isTemporary(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
 	}
-	return resp
+	if err == io.EOF {
+		return true
+	}
+	// net.OpError (a dial failure, among others) also implements
+	// net.Error, but neither a plain "connection refused" nor a closed
+	// connection is reported Temporary or Timeout by recent Go -- so
+	// this must fall through to the string check below rather than
+	// returning on this branch alone.
+	if nerr, ok := err.(net.Error); ok && (nerr.Temporary() || nerr.Timeout()) {
+		return true
+	}
+	return strings.Contains(err.Error(), "connection refused")
+}
+
+// MigrateOutputDir copies each of the given paths (e.g. the files of an
+// mumax3 output directory) whose ring owner changed (after
+// AddNode/RemoveNode) from its old backend to its new one. It does not
+// list any directory itself. oldRing should be a snapshot of the ring
+// taken before the node change; paths already on the right backend are
+// left alone.
+func (f *Client) MigrateOutputDir(paths []string, oldRing *hashRing) error {
+	for _, p := range paths {
+		oldHost := oldRing.Get(p)
+		newHost := f.hostFor(p)
+		if oldHost == newHost {
+			continue
+		}
+		src, err := f.openOn(oldHost, p)
+		if err != nil {
+			return fmt.Errorf("httpfs: migrate %v: %v", p, err)
+		}
+		dst, err := f.openOn(newHost, p)
+		if err != nil {
+			src.Close()
+			return fmt.Errorf("httpfs: migrate %v: %v", p, err)
+		}
+		_, err = io.Copy(dst, src)
+		src.Close()
+		dst.Close()
+		if err != nil {
+			return fmt.Errorf("httpfs: migrate %v: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// openOn opens name on a specific backend, bypassing the ring. Used by
+// MigrateOutputDir to read from the old owner and write to the new one.
+func (f *Client) openOn(host, name string) (*File, error) {
+	u := url.URL{Scheme: "http", Host: host, Path: name}
+	q := u.Query()
+	q.Set("flag", fmt.Sprint(os.O_RDWR|os.O_CREATE))
+	q.Set("perm", fmt.Sprint(uint32(0666)))
+	u.RawQuery = q.Encode()
+
+	resp := f.do("OPEN", u.String(), nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(`status %v: "%s"`, resp.StatusCode, resp.Header.Get(X_ERROR))
+	}
+	fd := readUInt(resp.Body)
+	if fd < 0 {
+		return nil, fmt.Errorf("invalid argument")
+	}
+
+	f.mutex.Lock()
+	f.fds[uintptr(fd)] = &fdInfo{host: host, path: name, flag: os.O_RDWR | os.O_CREATE, perm: 0666}
+	f.mutex.Unlock()
+
+	fdURL := url.URL{Scheme: "http", Host: host, Path: fmt.Sprint(fd)}
+	return &File{client: f, u: fdURL, name: name, fd: uintptr(fd)}, nil
 }
 
 // TODO: rm
@@ -97,5 +397,4 @@ func readUInt(r io.Reader) int {
 	return v
 }
 
-//TODO: disconnect, keepalive, close all files on disconnect/reconnect
-//TODO: return *os.PathError
\ No newline at end of file
+//TODO: return *os.PathError
@@ -0,0 +1,104 @@
+package httpfs
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestIsTemporaryUnwrapsConnectionRefused drives an actual refused
+// connection through http.Client.Do -- which always wraps the
+// underlying error in a *url.Error -- and checks isTemporary still
+// recognizes it, rather than asserting on a raw net.OpError by hand.
+func TestIsTemporaryUnwrapsConnectionRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close() // nothing listens here anymore: connections are refused
+
+	resp, err := http.Get("http://" + addr)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected a connection-refused error")
+	}
+	if !isTemporary(err) {
+		t.Fatalf("isTemporary(%v) = false, want true for connection refused", err)
+	}
+}
+
+// TestIsTemporaryUnwrapsEOF drives a server that accepts and closes the
+// connection immediately -- producing io.EOF wrapped in a *url.Error --
+// through isTemporary the same way.
+func TestIsTemporaryUnwrapsEOF(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	_, err = http.Get("http://" + l.Addr().String())
+	if err == nil {
+		t.Fatal("expected an EOF error")
+	}
+	if !isTemporary(err) {
+		t.Fatalf("isTemporary(%v) = false, want true for EOF", err)
+	}
+}
+
+// TestDoRetriesOnTemporaryFailureThenSucceeds checks the retry loop
+// itself: a backend that fails the first two connections and then
+// serves should still yield a real 200, not the fake StatusTeapot
+// do() falls back to once retries are exhausted.
+func TestDoRetriesOnTemporaryFailureThenSucceeds(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var attempts int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if atomic.AddInt32(&attempts, 1) <= 2 {
+				conn.Close() // simulate a transient failure
+				continue
+			}
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+			conn.Close()
+		}
+	}()
+
+	fs := &Client{
+		serverAddr: l.Addr().String(),
+		backoff:    BackoffPolicy{Initial: time.Millisecond, Max: 10 * time.Millisecond, Factor: 2},
+		fds:        make(map[uintptr]*fdInfo),
+	}
+	var reconnected bool
+	fs.OnReconnect(func() { reconnected = true })
+
+	resp := fs.do("GET", "http://"+l.Addr().String()+"/", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("do() = status %v, want 200 after retrying past the transient failures", resp.StatusCode)
+	}
+	if !reconnected {
+		t.Fatal("OnReconnect was not invoked after do() retried and succeeded")
+	}
+}
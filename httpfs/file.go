@@ -0,0 +1,123 @@
+package httpfs
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+)
+
+// A File is a handle to a file opened on an httpfs server, similar to
+// os.File. Reads, writes and seeks go through Client.doFD so a server
+// restart (fd table gone) is transparently recovered from by
+// re-opening the file and seeking back to the last known offset.
+type File struct {
+	client *Client
+	name   string
+
+	mutex  sync.Mutex
+	u      url.URL // host + fd path, updated in place if doFD reopens
+	fd     uintptr
+	offset int64
+}
+
+// Name returns the name of the file as passed to OpenFile.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Read implements io.Reader.
+func (f *File) Read(p []byte) (int, error) {
+	f.mutex.Lock()
+	fd, u := f.fd, f.u
+	f.mutex.Unlock()
+
+	q := u.Query()
+	q.Set("n", fmt.Sprint(len(p)))
+	u.RawQuery = q.Encode()
+
+	resp, newFd := f.client.doFD(fd, "READ", u.String(), nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf(`httpfs read "%v": status %v: "%s"`, f.name, resp.StatusCode, resp.Header.Get(X_ERROR))
+	}
+
+	n, err := resp.Body.Read(p)
+	f.advance(newFd, int64(n))
+	if n == 0 && err == nil {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Write implements io.Writer.
+func (f *File) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	fd, u := f.fd, f.u
+	f.mutex.Unlock()
+
+	resp, newFd := f.client.doFD(fd, "WRITE", u.String(), p)
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf(`httpfs write "%v": status %v: "%s"`, f.name, resp.StatusCode, resp.Header.Get(X_ERROR))
+	}
+
+	f.advance(newFd, int64(len(p)))
+	return len(p), nil
+}
+
+// Seek implements io.Seeker.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mutex.Lock()
+	fd, u := f.fd, f.u
+	f.mutex.Unlock()
+
+	q := u.Query()
+	q.Set("offset", fmt.Sprint(offset))
+	q.Set("whence", fmt.Sprint(whence))
+	u.RawQuery = q.Encode()
+
+	resp, newFd := f.client.doFD(fd, "SEEK", u.String(), nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf(`httpfs seek "%v": status %v: "%s"`, f.name, resp.StatusCode, resp.Header.Get(X_ERROR))
+	}
+	newOffset := int64(readUInt(resp.Body))
+
+	f.mutex.Lock()
+	f.fd = newFd
+	f.u.Host, f.u.Path = f.client.hostOf(newFd), fmt.Sprint(newFd)
+	f.offset = newOffset
+	f.mutex.Unlock()
+	f.client.setOffset(newFd, newOffset)
+	return newOffset, nil
+}
+
+// Close closes the file, forgetting its server-side fd.
+func (f *File) Close() error {
+	f.mutex.Lock()
+	fd, u := f.fd, f.u
+	f.mutex.Unlock()
+
+	resp, newFd := f.client.doFD(fd, "CLOSE", u.String(), nil)
+	resp.Body.Close()
+	f.client.closeFD(newFd)
+	if resp.StatusCode != 200 {
+		return fmt.Errorf(`httpfs close "%v": status %v: "%s"`, f.name, resp.StatusCode, resp.Header.Get(X_ERROR))
+	}
+	return nil
+}
+
+// advance updates the locally tracked offset and fd (doFD may have
+// transparently reopened the file onto a new fd) after a read or write.
+func (f *File) advance(newFd uintptr, n int64) {
+	f.mutex.Lock()
+	if newFd != f.fd {
+		f.fd = newFd
+		f.u.Host, f.u.Path = f.client.hostOf(newFd), fmt.Sprint(newFd)
+	}
+	f.offset += n
+	offset := f.offset
+	f.mutex.Unlock()
+	f.client.setOffset(newFd, offset)
+}
@@ -0,0 +1,103 @@
+package httpfs
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"sync"
+)
+
+// vnodesPerBackend is the number of virtual nodes hashed onto the ring
+// for each backend, so that load spreads evenly even with few backends.
+const vnodesPerBackend = 150
+
+// hashRing assigns file paths to backend addresses by consistent
+// hashing, so adding or removing a backend only moves ~1/N of the
+// paths rather than reshuffling everything.
+type hashRing struct {
+	mutex sync.RWMutex
+	keys  []uint32          // sorted virtual node hashes
+	nodes map[uint32]string // virtual node hash -> backend addr
+	addrs []string          // backend addresses currently on the ring
+
+	// writeMutex serializes AddNode/RemoveNode so a read-modify-write
+	// of addrs can't race another and silently clobber its change.
+	// mutex alone isn't enough: it's only held across rebuild's own
+	// write, not across the read-Nodes()-then-rebuild() spanning it.
+	writeMutex sync.Mutex
+}
+
+func newHashRing(addrs []string) *hashRing {
+	r := &hashRing{}
+	r.rebuild(addrs)
+	return r
+}
+
+// rebuild recomputes the ring from scratch for the given backend set.
+func (r *hashRing) rebuild(addrs []string) {
+	keys := make([]uint32, 0, len(addrs)*vnodesPerBackend)
+	nodes := make(map[uint32]string, len(addrs)*vnodesPerBackend)
+	for _, addr := range addrs {
+		for i := 0; i < vnodesPerBackend; i++ {
+			h := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%v#%v", addr, i)))
+			keys = append(keys, h)
+			nodes[h] = addr
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	r.mutex.Lock()
+	r.keys = keys
+	r.nodes = nodes
+	r.addrs = append([]string{}, addrs...)
+	r.mutex.Unlock()
+}
+
+// Get returns the backend address responsible for path.
+func (r *hashRing) Get(path string) string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if len(r.keys) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(path))
+	i := sort.Search(len(r.keys), func(i int) bool { return r.keys[i] >= h })
+	if i == len(r.keys) {
+		i = 0 // wrap around
+	}
+	return r.nodes[r.keys[i]]
+}
+
+// Nodes returns the backend addresses currently on the ring.
+func (r *hashRing) Nodes() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return append([]string{}, r.addrs...)
+}
+
+// AddNode adds a backend to the ring, rebuilding it so only the
+// paths that now hash to the new backend need to migrate.
+func (r *hashRing) AddNode(addr string) {
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+	for _, a := range r.Nodes() {
+		if a == addr {
+			return
+		}
+	}
+	r.rebuild(append(r.Nodes(), addr))
+}
+
+// RemoveNode removes a backend from the ring, rebuilding it so only
+// the paths that hashed to the removed backend need to migrate.
+func (r *hashRing) RemoveNode(addr string) {
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+	addrs := r.Nodes()
+	for i, a := range addrs {
+		if a == addr {
+			r.rebuild(append(addrs[:i], addrs[i+1:]...))
+			return
+		}
+	}
+}
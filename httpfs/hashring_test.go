@@ -0,0 +1,109 @@
+package httpfs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestHashRingGetIsStable(t *testing.T) {
+	r := newHashRing([]string{"a:1", "b:2", "c:3"})
+	for i := 0; i < 100; i++ {
+		path := fmt.Sprintf("/out/m%v.ovf", i)
+		first := r.Get(path)
+		if second := r.Get(path); first != second {
+			t.Fatalf("Get(%v) not stable: %v then %v", path, first, second)
+		}
+	}
+}
+
+func TestHashRingGetOnlyReturnsKnownNodes(t *testing.T) {
+	addrs := []string{"a:1", "b:2", "c:3"}
+	r := newHashRing(addrs)
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		seen[r.Get(fmt.Sprintf("/out/m%v.ovf", i))] = true
+	}
+	for addr := range seen {
+		found := false
+		for _, a := range addrs {
+			if a == addr {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("Get returned unknown node %v", addr)
+		}
+	}
+	if len(seen) != len(addrs) {
+		t.Fatalf("expected all %v backends to receive some path, got %v", len(addrs), len(seen))
+	}
+}
+
+func TestHashRingAddNodeMovesFewKeys(t *testing.T) {
+	r := newHashRing([]string{"a:1", "b:2", "c:3"})
+	const n = 1000
+	paths := make([]string, n)
+	before := make([]string, n)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/out/m%v.ovf", i)
+		before[i] = r.Get(paths[i])
+	}
+
+	r.AddNode("d:4")
+
+	moved := 0
+	for i, p := range paths {
+		if r.Get(p) != before[i] {
+			moved++
+		}
+	}
+	// consistent hashing should move roughly 1/(N+1) of the keys, not everything.
+	if moved > n/2 {
+		t.Fatalf("AddNode moved %v/%v keys, expected roughly %v", moved, n, n/4)
+	}
+}
+
+func TestHashRingRemoveNode(t *testing.T) {
+	r := newHashRing([]string{"a:1", "b:2", "c:3"})
+	r.RemoveNode("b:2")
+	for _, addr := range r.Nodes() {
+		if addr == "b:2" {
+			t.Fatalf("RemoveNode did not remove b:2: %v", r.Nodes())
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if got := r.Get(fmt.Sprintf("/out/m%v.ovf", i)); got == "b:2" {
+			t.Fatalf("Get still routes to removed node b:2")
+		}
+	}
+}
+
+// TestHashRingConcurrentAddNodeDoesNotLoseUpdates drives many concurrent
+// AddNode calls for distinct backends and checks every one of them
+// survives: a read-Nodes()-then-rebuild() race would let a later
+// rebuild clobber an earlier AddNode with no error.
+func TestHashRingConcurrentAddNodeDoesNotLoseUpdates(t *testing.T) {
+	r := newHashRing([]string{"a:1", "b:2", "c:3"})
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.AddNode(fmt.Sprintf("new%v:1", i))
+		}(i)
+	}
+	wg.Wait()
+
+	nodes := make(map[string]bool)
+	for _, a := range r.Nodes() {
+		nodes[a] = true
+	}
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("new%v:1", i)
+		if !nodes[addr] {
+			t.Fatalf("AddNode lost concurrent update: %v missing from %v", addr, r.Nodes())
+		}
+	}
+}